@@ -0,0 +1,29 @@
+package ratelimit
+
+import "testing"
+
+func TestAllow(t *testing.T) {
+	tb := NewBucket(infinityDuration, 1, 1, 1)
+	if !tb.Allow() {
+		t.Fatal("first Allow should succeed with a full bucket")
+	}
+	if tb.Allow() {
+		t.Fatal("second immediate Allow should fail, no tokens left")
+	}
+}
+
+func TestAllowNDoesNotPartiallyConsume(t *testing.T) {
+	tb := NewBucket(infinityDuration, 5, 5, 5)
+	if tb.AllowN(10) {
+		t.Fatal("AllowN(10) on a 5-capacity bucket should fail")
+	}
+	if avail := tb.Available(); avail != 5 {
+		t.Fatalf("available = %d, want 5 (a failed AllowN must not consume any tokens)", avail)
+	}
+	if !tb.AllowN(5) {
+		t.Fatal("AllowN(5) should succeed once it fits exactly")
+	}
+	if avail := tb.Available(); avail != 0 {
+		t.Fatalf("available = %d, want 0", avail)
+	}
+}
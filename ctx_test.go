@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTakeCtxSucceedsImmediately(t *testing.T) {
+	tb := NewBucket(time.Second, 10, 10, 10)
+	d, err := tb.TakeCtx(context.Background(), 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d != 0 {
+		t.Fatalf("wait = %v, want 0 when tokens are already available", d)
+	}
+	if avail := tb.Available(); avail != 5 {
+		t.Fatalf("available = %d, want 5", avail)
+	}
+}
+
+func TestTakeCtxWaitsThenSucceeds(t *testing.T) {
+	tb := NewBucket(20*time.Millisecond, 1, 1, 0)
+	start := time.Now()
+	d, err := tb.TakeCtx(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d <= 0 {
+		t.Fatalf("wait = %v, want > 0 since the bucket started empty", d)
+	}
+	if elapsed := time.Since(start); elapsed < d {
+		t.Fatalf("TakeCtx returned after %v, less than its reported wait %v", elapsed, d)
+	}
+}
+
+func TestTakeCtxDeadlineExceededUpFront(t *testing.T) {
+	tb := NewBucket(time.Hour, 1, 1, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := tb.TakeCtx(ctx, 1)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("TakeCtx took %v, should have returned immediately instead of sleeping past the deadline", elapsed)
+	}
+	if avail := tb.Available(); avail != 0 {
+		t.Fatalf("available = %d, want 0 (a deadline-exceeded TakeCtx must not consume tokens)", avail)
+	}
+}
+
+func TestTakeCtxCancelledWhileWaiting(t *testing.T) {
+	tb := NewBucket(time.Hour, 1, 1, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := tb.TakeCtx(ctx, 1)
+		errc <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errc:
+		if err != context.Canceled {
+			t.Fatalf("err = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("TakeCtx did not return after cancellation")
+	}
+	if avail := tb.Available(); avail != 0 {
+		t.Fatalf("available = %d, want 0 (cancelling the only reservation restores the pre-reservation state)", avail)
+	}
+}
+
+func TestWaitCtxCancelledWhileWaiting(t *testing.T) {
+	tb := NewBucket(time.Hour, 1, 1, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- tb.WaitCtx(ctx, 1)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errc:
+		if err != context.Canceled {
+			t.Fatalf("err = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("WaitCtx did not return after cancellation")
+	}
+}
@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetRateIncreasesFillSpeed(t *testing.T) {
+	tb := NewBucket(100*time.Millisecond, 10, 1, 0)
+	tb.SetRate(100) // 100 tokens/sec == 10 tokens per 100ms interval
+
+	time.Sleep(150 * time.Millisecond)
+	if avail := tb.Available(); avail < 9 {
+		t.Fatalf("available = %d, want at least 9 after rate increase", avail)
+	}
+}
+
+func TestSetRateDecreasesFillSpeed(t *testing.T) {
+	tb := NewBucket(10*time.Millisecond, 10, 10, 0)
+	tb.SetRate(10) // 10 tokens/sec == 0.1 tokens per 10ms interval, clamped to quantum 1
+
+	time.Sleep(15 * time.Millisecond)
+	if avail := tb.Available(); avail > 2 {
+		t.Fatalf("available = %d, want at most 2 after rate decrease", avail)
+	}
+}
+
+func TestSetRateDoesNotWakeParkedWaiters(t *testing.T) {
+	tb := NewBucket(100*time.Millisecond, 1, 1, 0)
+
+	done := make(chan time.Duration, 1)
+	start := time.Now()
+	go func() {
+		tb.Wait(1)
+		done <- time.Since(start)
+	}()
+
+	// Give the waiter time to park on its already-computed sleep, then
+	// speed up the bucket. The waiter should not notice.
+	time.Sleep(20 * time.Millisecond)
+	tb.SetRate(1e9)
+
+	select {
+	case elapsed := <-done:
+		if elapsed < 90*time.Millisecond {
+			t.Fatalf("waiter woke early after SetRate, elapsed = %v", elapsed)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("waiter never woke")
+	}
+}
+
+func TestSetFillIntervalPreservesAccruedTokens(t *testing.T) {
+	tb := NewBucket(time.Second, 10, 5, 5)
+	tb.SetFillInterval(10*time.Millisecond, 5)
+
+	if avail := tb.Available(); avail != 5 {
+		t.Fatalf("available = %d, want 5 immediately after SetFillInterval", avail)
+	}
+
+	time.Sleep(15 * time.Millisecond)
+	if avail := tb.Available(); avail != 10 {
+		t.Fatalf("available = %d, want 10 after waiting one new interval", avail)
+	}
+}
+
+func TestConcurrentSetCapacityAndReadersAreRaceFree(t *testing.T) {
+	tb := NewBucket(time.Millisecond, 10, 1, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			tb.SetCapacity(int64(1 + i%10))
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		_ = tb.Capacity()
+		_ = tb.Rate()
+	}
+	<-done
+}
+
+func TestSetCapacityClampsAvailableTokens(t *testing.T) {
+	tb := NewBucket(time.Second, 10, 1, 10)
+	tb.SetCapacity(3)
+
+	if avail := tb.Available(); avail != 3 {
+		t.Fatalf("available = %d, want 3 after capacity reduced", avail)
+	}
+	if cap := tb.Capacity(); cap != 3 {
+		t.Fatalf("capacity = %d, want 3", cap)
+	}
+}
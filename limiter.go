@@ -0,0 +1,183 @@
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// numShards is the number of independent shards a Limiter splits its
+// keyspace across, each guarded by its own mutex, to keep lock
+// contention down under concurrent per-key traffic.
+const numShards = 16
+
+// BucketConfig describes the rate limit applied to every key managed by
+// a Limiter; it is the per-key equivalent of the arguments to NewBucket.
+type BucketConfig struct {
+	FillInterval time.Duration
+	Capacity     int64
+	Quantum      int64
+}
+
+// Limiter maps arbitrary keys, such as an IP address, user ID or API
+// token, to independent Buckets that all share the same BucketConfig.
+// Buckets are created lazily on first use and evicted, LRU-first, once
+// a shard holds more than its share of maxKeys entries or a bucket has
+// been idle for longer than idle. Methods on Limiter may be called
+// concurrently.
+type Limiter struct {
+	cfg     BucketConfig
+	maxKeys int
+	idle    time.Duration
+	shards  [numShards]*limiterShard
+}
+
+// NewLimiter returns a Limiter whose Buckets are created with cfg.
+// maxKeys is split evenly across the shards, each of which keeps at most
+// ceil(maxKeys/numShards) buckets, so the Limiter as a whole can hold up
+// to numShards*ceil(maxKeys/numShards) buckets (0 means unlimited). A
+// bucket that hasn't been used for idle is evicted even if maxKeys hasn't
+// been reached (0 means never evict on idle alone); this happens only
+// when a new key is added to the same shard.
+func NewLimiter(cfg BucketConfig, maxKeys int, idle time.Duration) *Limiter {
+	if cfg.FillInterval <= 0 {
+		panic("token bucket fill interval is not > 0")
+	}
+	if cfg.Capacity <= 0 {
+		panic("token bucket capacity is not > 0")
+	}
+	perShardMax := 0
+	if maxKeys > 0 {
+		perShardMax = (maxKeys + numShards - 1) / numShards
+		if perShardMax < 1 {
+			perShardMax = 1
+		}
+	}
+	l := &Limiter{cfg: cfg, maxKeys: maxKeys, idle: idle}
+	for i := range l.shards {
+		l.shards[i] = &limiterShard{
+			cfg:     cfg,
+			maxKeys: perShardMax,
+			idle:    idle,
+			items:   make(map[string]*list.Element),
+			ll:      list.New(),
+		}
+	}
+	return l
+}
+
+// Allow reports whether a single token is immediately available for key,
+// consuming it if so. It never blocks.
+func (l *Limiter) Allow(key string) bool {
+	return l.AllowN(key, 1)
+}
+
+// AllowN reports whether n tokens are immediately available for key,
+// consuming them atomically if so. It never blocks and never consumes a
+// partial count: either all n tokens are taken, or none are.
+func (l *Limiter) AllowN(key string, n int64) bool {
+	return l.bucketFor(key).AllowN(n)
+}
+
+// Wait takes a single token from key's bucket, blocking until it is
+// available or ctx is done.
+func (l *Limiter) Wait(ctx context.Context, key string) error {
+	return l.bucketFor(key).WaitCtx(ctx, 1)
+}
+
+// Reserve reserves n tokens from key's bucket for future use. See
+// Bucket.Reserve.
+func (l *Limiter) Reserve(key string, n int64) *Reservation {
+	return l.bucketFor(key).Reserve(n)
+}
+
+// bucketFor returns the Bucket for key, creating it if this is the
+// key's first use.
+func (l *Limiter) bucketFor(key string) *Bucket {
+	return l.shardFor(key).getBucket(key, time.Now())
+}
+
+// shardFor returns the shard responsible for key.
+func (l *Limiter) shardFor(key string) *limiterShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return l.shards[h.Sum32()%numShards]
+}
+
+// limiterShard owns a slice of a Limiter's keyspace: a map of key to LRU
+// list element, backed by a container/list so the least recently used
+// bucket can be evicted in O(1).
+type limiterShard struct {
+	cfg     BucketConfig
+	maxKeys int
+	idle    time.Duration
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+// limiterEntry is the value stored in a shard's LRU list.
+type limiterEntry struct {
+	key      string
+	bucket   *Bucket
+	lastUsed time.Time
+}
+
+// getBucket returns the bucket for key, creating it (and evicting idle
+// or excess entries first) if necessary.
+func (s *limiterShard) getBucket(key string, now time.Time) *Bucket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.items[key]; ok {
+		ent := el.Value.(*limiterEntry)
+		ent.lastUsed = now
+		s.ll.MoveToFront(el)
+		return ent.bucket
+	}
+
+	s.evictIdleLocked(now)
+	if s.maxKeys > 0 && s.ll.Len() >= s.maxKeys {
+		s.evictOldestLocked()
+	}
+
+	bucket := NewBucket(s.cfg.FillInterval, s.cfg.Capacity, s.cfg.Quantum, s.cfg.Capacity)
+	el := s.ll.PushFront(&limiterEntry{key: key, bucket: bucket, lastUsed: now})
+	s.items[key] = el
+	return bucket
+}
+
+// evictIdleLocked removes every entry at the back of the LRU list that
+// has been idle for longer than s.idle. s.mu must be held.
+func (s *limiterShard) evictIdleLocked(now time.Time) {
+	if s.idle <= 0 {
+		return
+	}
+	for {
+		el := s.ll.Back()
+		if el == nil {
+			return
+		}
+		ent := el.Value.(*limiterEntry)
+		if now.Sub(ent.lastUsed) < s.idle {
+			return
+		}
+		s.ll.Remove(el)
+		delete(s.items, ent.key)
+	}
+}
+
+// evictOldestLocked removes the least recently used entry. s.mu must be
+// held.
+func (s *limiterShard) evictOldestLocked() {
+	el := s.ll.Back()
+	if el == nil {
+		return
+	}
+	ent := el.Value.(*limiterEntry)
+	s.ll.Remove(el)
+	delete(s.items, ent.key)
+}
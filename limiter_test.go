@@ -0,0 +1,89 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiterAllowPerKey(t *testing.T) {
+	l := NewLimiter(BucketConfig{FillInterval: time.Second, Capacity: 1, Quantum: 1}, 0, 0)
+
+	if !l.Allow("a") {
+		t.Fatal("first request for key a should be allowed")
+	}
+	if l.Allow("a") {
+		t.Fatal("second immediate request for key a should be denied")
+	}
+	if !l.Allow("b") {
+		t.Fatal("key b has its own bucket and should be allowed")
+	}
+}
+
+func TestLimiterAllowNIsAtomic(t *testing.T) {
+	l := NewLimiter(BucketConfig{FillInterval: time.Second, Capacity: 5, Quantum: 5}, 0, 0)
+
+	if l.AllowN("a", 10) {
+		t.Fatal("requesting more tokens than capacity should be denied")
+	}
+	if !l.Allow("a") {
+		t.Fatal("a failed AllowN must not have partially drained the bucket")
+	}
+}
+
+func TestLimiterWaitBlocksUntilAvailable(t *testing.T) {
+	l := NewLimiter(BucketConfig{FillInterval: 20 * time.Millisecond, Capacity: 1, Quantum: 1}, 0, 0)
+
+	if !l.Allow("a") {
+		t.Fatal("first request should be allowed")
+	}
+
+	start := time.Now()
+	if err := l.Wait(context.Background(), "a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Fatalf("Wait returned after %v, expected to block for a refill", elapsed)
+	}
+}
+
+func TestLimiterEvictsIdleKeys(t *testing.T) {
+	l := NewLimiter(BucketConfig{FillInterval: time.Second, Capacity: 1, Quantum: 1}, 0, 10*time.Millisecond)
+
+	shard := l.shardFor("a")
+	shard.getBucket("a", time.Now())
+	if got := shard.ll.Len(); got != 1 {
+		t.Fatalf("shard has %d entries, want 1", got)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	shard.getBucket("b", time.Now())
+	if got := shard.ll.Len(); got != 1 {
+		t.Fatalf("shard has %d entries after idle eviction, want 1 (only b)", got)
+	}
+	if _, ok := shard.items["a"]; ok {
+		t.Fatal("idle key a should have been evicted")
+	}
+}
+
+func TestLimiterEvictsLRUWhenFull(t *testing.T) {
+	l := NewLimiter(BucketConfig{FillInterval: time.Second, Capacity: 1, Quantum: 1}, numShards, 0)
+
+	shard := l.shardFor("k1")
+	if shard.maxKeys != 1 {
+		t.Fatalf("perShardMax = %d, want 1 for a %d-key limit", shard.maxKeys, numShards)
+	}
+	b1 := shard.getBucket("k1", time.Now())
+	b2 := shard.getBucket("k1", time.Now())
+	if b1 != b2 {
+		t.Fatal("repeated lookups of the same key should return the same bucket")
+	}
+
+	shard.getBucket("k2", time.Now())
+	if shard.ll.Len() != 1 {
+		t.Fatalf("shard has %d entries, want 1 after evicting k1 for k2", shard.ll.Len())
+	}
+	if _, ok := shard.items["k1"]; ok {
+		t.Fatal("k1 should have been evicted to make room for k2")
+	}
+}
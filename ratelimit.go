@@ -0,0 +1,352 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+// Package ratelimit provides an efficient token bucket implementation
+// that can be used to limit the rate of arbitrary things.
+// See http://en.wikipedia.org/wiki/Token_bucket.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// infinityDuration is a duration that is effectively infinite, used as
+// the default maxWait for calls that should never give up.
+const infinityDuration time.Duration = 0x7fffffffffffffff
+
+// Bucket represents a token bucket that fills at a predetermined rate.
+// Methods on Bucket may be called concurrently.
+type Bucket struct {
+	startTime    time.Time
+	capacity     int64
+	quantum      int64
+	fillInterval time.Duration
+
+	// batch is set to 10 for buckets created with New and is zero for
+	// buckets created with NewBucket. The refill logic does not read it:
+	// every tick adds quantum tokens.
+	batch int64
+
+	// mu guards the fields below it.
+	mu              sync.Mutex
+	availableTokens int64
+	latestTick      int64
+
+	// lastReservationTokens and lastReservationTime record the most
+	// recently made reservation, so that Reservation.Cancel can tell
+	// whether it is cancelling the last reservation made (and so can
+	// safely give its tokens back) or whether a later reservation has
+	// already been made on the strength of them.
+	lastReservationTokens int64
+	lastReservationTime   time.Time
+}
+
+// NewBucket returns a new token bucket that fills at the rate of quantum
+// tokens every fillInterval, up to the given maximum capacity, starting
+// with available tokens. Both fillInterval and capacity must be positive.
+func NewBucket(fillInterval time.Duration, capacity, quantum, available int64) *Bucket {
+	if fillInterval <= 0 {
+		panic("token bucket fill interval is not > 0")
+	}
+	if capacity <= 0 {
+		panic("token bucket capacity is not > 0")
+	}
+	if quantum <= 0 {
+		quantum = 1
+	}
+	return &Bucket{
+		startTime:       time.Now(),
+		capacity:        capacity,
+		quantum:         quantum,
+		fillInterval:    fillInterval,
+		availableTokens: available,
+	}
+}
+
+// New returns a new token bucket with a capacity of rate tokens that
+// refills, in batches, at approximately rate tokens per second. It is a
+// convenient shorthand for NewBucket for the common case of wanting a
+// bucket that limits to a given per-second rate.
+func New(rate int64) *Bucket {
+	quantum := rate / 1000
+	if quantum < 1 {
+		quantum = 1
+	}
+	tb := NewBucket(time.Millisecond, rate, quantum, rate)
+	tb.batch = 10
+	return tb
+}
+
+// Rate returns the fill rate of the bucket, in tokens per second.
+func (tb *Bucket) Rate() float64 {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	return 1e9 * float64(tb.quantum) / float64(tb.fillInterval)
+}
+
+// Capacity returns the bucket's current capacity. It may change over
+// the bucket's lifetime if SetCapacity is called.
+func (tb *Bucket) Capacity() int64 {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	return tb.capacity
+}
+
+// Available returns the number of available tokens. It will be negative
+// when there are consumers waiting for tokens. Note that if this
+// returns greater than zero, it does not guarantee that calls to Take
+// succeed, as the number of available tokens could be reduced by other
+// goroutines before the call is made.
+func (tb *Bucket) Available() int64 {
+	return tb.available(time.Now())
+}
+
+// available is the internal version of Available - it takes the current
+// time as an argument to enable easy testing.
+func (tb *Bucket) available(now time.Time) int64 {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.adjustavailableTokens(tb.currentTick(now))
+	return tb.availableTokens
+}
+
+// TakeAvailable takes up to count immediately available tokens from the
+// bucket. It returns the number of tokens removed, or zero if there are
+// no available tokens. It does not block.
+func (tb *Bucket) TakeAvailable(count int64) int64 {
+	return tb.takeAvailable(time.Now(), count)
+}
+
+// takeAvailable is the internal version of TakeAvailable - it takes the
+// current time as an argument to enable easy testing.
+func (tb *Bucket) takeAvailable(now time.Time, count int64) int64 {
+	if count <= 0 {
+		return 0
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.adjustavailableTokens(tb.currentTick(now))
+	if tb.availableTokens <= 0 {
+		return 0
+	}
+	if count > tb.availableTokens {
+		count = tb.availableTokens
+	}
+	tb.availableTokens -= count
+	return count
+}
+
+// Take takes count tokens from the bucket without blocking. It returns
+// the time that the caller should wait until the tokens are actually
+// available.
+//
+// Note that if the request is irrevocable - there is no way to return
+// tokens to the bucket once this method commits us to taking them.
+func (tb *Bucket) Take(count int64) time.Duration {
+	d, ok := tb.take(time.Now(), count, infinityDuration)
+	if !ok {
+		panic("unreachable")
+	}
+	return d
+}
+
+// TakeMaxDuration takes count tokens from the bucket without blocking. It
+// returns whether there were sufficient tokens, and if there were, the
+// time that the caller should wait until the tokens are actually
+// available.
+//
+// If the tokens would not be available within maxWait, it returns false,
+// and no tokens are removed. A count greater than the bucket's capacity
+// is still granted when the wait it needs is no longer than maxWait.
+func (tb *Bucket) TakeMaxDuration(count int64, maxWait time.Duration) (time.Duration, bool) {
+	return tb.take(time.Now(), count, maxWait)
+}
+
+// take is the internal version of Take - it takes the current time as
+// an argument to enable easy testing. It is implemented in terms of
+// reserve, which is the primitive that Take, Wait and Reserve all share.
+func (tb *Bucket) take(now time.Time, count int64, maxWait time.Duration) (time.Duration, bool) {
+	r := tb.reserve(now, count, maxWait)
+	if !r.ok {
+		return 0, false
+	}
+	return r.DelayFrom(now), true
+}
+
+// Wait takes count tokens from the bucket, waiting until they are
+// available.
+func (tb *Bucket) Wait(count int64) {
+	if d := tb.Take(count); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// WaitMaxDuration is like Wait except that it will only wait up to the
+// given maximum duration. If it would need to wait for longer than
+// that, it takes no tokens and reports false, otherwise it waits as
+// normal and reports true.
+func (tb *Bucket) WaitMaxDuration(count int64, maxWait time.Duration) bool {
+	d, ok := tb.TakeMaxDuration(count, maxWait)
+	if !ok {
+		return false
+	}
+	if d > 0 {
+		time.Sleep(d)
+	}
+	return true
+}
+
+// TakeCtx is like TakeMaxDuration except that the maximum wait is
+// governed by ctx rather than an explicit duration: ctx's deadline, if
+// any, is used as the maximum wait, and TakeCtx also returns early with
+// ctx.Err() if ctx is cancelled while waiting. If the deadline is too
+// close, no tokens are taken. If ctx is cancelled while waiting, the
+// reserved tokens are returned to the bucket, subject to the same rules
+// as Reservation.CancelAt: they are kept if a later reservation has
+// already been made.
+func (tb *Bucket) TakeCtx(ctx context.Context, count int64) (time.Duration, error) {
+	maxWait := infinityDuration
+	if deadline, ok := ctx.Deadline(); ok {
+		if w := time.Until(deadline); w < maxWait {
+			maxWait = w
+		}
+	}
+	now := time.Now()
+	r := tb.reserve(now, count, maxWait)
+	if !r.ok {
+		return 0, context.DeadlineExceeded
+	}
+	d := r.DelayFrom(now)
+	if d == 0 {
+		return 0, nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return d, nil
+	case <-ctx.Done():
+		// Go through the same Reservation.CancelAt bookkeeping as a
+		// plain Cancel, so tokens are only handed back if no later
+		// reservation has already relied on this one's tokens being
+		// spent.
+		r.CancelAt(time.Now())
+		return 0, ctx.Err()
+	}
+}
+
+// WaitCtx is like Wait except that it stops waiting and returns
+// ctx.Err() if ctx is cancelled, or its deadline passes, before count
+// tokens become available. On error, the reserved tokens are returned
+// to the bucket, subject to the same rules as Reservation.CancelAt.
+func (tb *Bucket) WaitCtx(ctx context.Context, count int64) error {
+	_, err := tb.TakeCtx(ctx, count)
+	return err
+}
+
+// SetRate adjusts the bucket's fill rate to approximately rate tokens per
+// second, keeping the existing fillInterval and recalculating quantum to
+// match. The new quantum is rate*fillInterval truncated to a whole number
+// and is never less than 1, so the bucket never fills slower than one
+// token per fillInterval. Tokens already accrued at the old rate are preserved, and
+// availableTokens is clamped to the bucket's capacity. Callers already
+// parked in Wait are not woken early; they continue to wait out the
+// duration computed at the time they called Take.
+func (tb *Bucket) SetRate(rate float64) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.adjustavailableTokens(tb.currentTick(time.Now()))
+	quantum := int64(rate * tb.fillInterval.Seconds())
+	if quantum < 1 {
+		quantum = 1
+	}
+	tb.quantum = quantum
+	if tb.availableTokens > tb.capacity {
+		tb.availableTokens = tb.capacity
+	}
+}
+
+// SetFillInterval changes the bucket's fillInterval and quantum, i.e. how
+// many tokens are added every d. Because the tick boundaries are defined
+// in units of fillInterval, the bucket's clock is reset to now so that
+// future ticks are measured against the new interval; tokens already
+// accrued at the old rate are preserved and clamped to capacity.
+func (tb *Bucket) SetFillInterval(d time.Duration, quantum int64) {
+	if d <= 0 {
+		panic("token bucket fill interval is not > 0")
+	}
+	if quantum <= 0 {
+		quantum = 1
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	now := time.Now()
+	tb.adjustavailableTokens(tb.currentTick(now))
+	tb.startTime = now
+	tb.latestTick = 0
+	tb.fillInterval = d
+	tb.quantum = quantum
+	if tb.availableTokens > tb.capacity {
+		tb.availableTokens = tb.capacity
+	}
+}
+
+// SetCapacity changes the bucket's maximum capacity. If the new capacity
+// is lower than the number of tokens currently available, the excess
+// tokens are discarded.
+func (tb *Bucket) SetCapacity(capacity int64) {
+	if capacity <= 0 {
+		panic("token bucket capacity is not > 0")
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.adjustavailableTokens(tb.currentTick(time.Now()))
+	tb.capacity = capacity
+	if tb.availableTokens > tb.capacity {
+		tb.availableTokens = tb.capacity
+	}
+}
+
+// Allow reports whether a single token is immediately available,
+// consuming it if so. It never blocks.
+func (tb *Bucket) Allow() bool {
+	return tb.AllowN(1)
+}
+
+// AllowN reports whether n tokens are immediately available, consuming
+// them atomically if so. Unlike TakeAvailable, which returns whatever is
+// available up to n, AllowN either takes all n tokens or none: there is
+// no partial consumption. This is the primitive for callers, such as
+// HTTP middleware, that want to reject rather than wait.
+func (tb *Bucket) AllowN(n int64) bool {
+	return tb.AllowAt(time.Now(), n)
+}
+
+// AllowAt is the time-parameterised version of AllowN, exposed to allow
+// deterministic testing.
+func (tb *Bucket) AllowAt(t time.Time, n int64) bool {
+	return tb.reserve(t, n, 0).OK()
+}
+
+// currentTick returns the current time tick, measured from tb.startTime.
+func (tb *Bucket) currentTick(now time.Time) int64 {
+	return int64(now.Sub(tb.startTime) / tb.fillInterval)
+}
+
+// adjustavailableTokens adjusts the current number of tokens available
+// in the bucket at the given time, which must be later than the
+// previously adjusted time.
+func (tb *Bucket) adjustavailableTokens(tick int64) {
+	if tb.availableTokens >= tb.capacity {
+		tb.latestTick = tick
+		return
+	}
+	tb.availableTokens += (tick - tb.latestTick) * tb.quantum
+	if tb.availableTokens > tb.capacity {
+		tb.availableTokens = tb.capacity
+	}
+	tb.latestTick = tick
+}
@@ -0,0 +1,45 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+)
+
+// Reader wraps an io.Reader so that each byte read from it also consumes
+// one token from a Bucket, blocking via Bucket.Wait so that the rate of
+// bytes read never exceeds the bucket's configured rate.
+type Reader struct {
+	r      io.Reader
+	bucket *Bucket
+	ctx    context.Context
+}
+
+// NewReader returns an io.Reader that reads from r but blocks so that
+// the rate of bytes read never exceeds the rate of b.
+func NewReader(r io.Reader, b *Bucket) io.Reader {
+	return &Reader{r: r, bucket: b, ctx: context.Background()}
+}
+
+// NewReaderCtx is like NewReader except that a Read which would
+// otherwise block returns ctx.Err() promptly if ctx is cancelled or its
+// deadline passes.
+func NewReaderCtx(ctx context.Context, r io.Reader, b *Bucket) io.Reader {
+	return &Reader{r: r, bucket: b, ctx: ctx}
+}
+
+// Read implements io.Reader. A single large Read is capped to the
+// bucket's capacity, so that it doesn't have to wait for the whole
+// transfer's worth of tokens before returning any data.
+func (r *Reader) Read(buf []byte) (int, error) {
+	if max := r.bucket.Capacity(); int64(len(buf)) > max {
+		buf = buf[:max]
+	}
+	n, err := r.r.Read(buf)
+	if n <= 0 {
+		return n, err
+	}
+	if werr := r.bucket.WaitCtx(r.ctx, int64(n)); werr != nil {
+		return n, werr
+	}
+	return n, err
+}
@@ -0,0 +1,99 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestReaderLimitsRate(t *testing.T) {
+	data := make([]byte, 100)
+	tb := NewBucket(10*time.Millisecond, 10, 10, 10)
+	r := NewReader(bytes.NewReader(data), tb)
+
+	start := time.Now()
+	n, err := io.Copy(io.Discard, r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != int64(len(data)) {
+		t.Fatalf("copied %d bytes, want %d", n, len(data))
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Fatalf("copy finished in %v, too fast for the configured rate", elapsed)
+	}
+}
+
+func TestReaderChunksLargeReads(t *testing.T) {
+	data := make([]byte, 1000)
+	tb := NewBucket(time.Second, 100, 100, 100)
+	r := NewReader(bytes.NewReader(data), tb)
+
+	n, err := r.Read(make([]byte, 1000))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 100 {
+		t.Fatalf("read %d bytes, want 100 (capped to the bucket's capacity)", n)
+	}
+}
+
+func TestWriterLimitsRate(t *testing.T) {
+	tb := NewBucket(10*time.Millisecond, 10, 10, 10)
+	w := NewWriter(io.Discard, tb)
+
+	start := time.Now()
+	n, err := w.Write(make([]byte, 100))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 100 {
+		t.Fatalf("wrote %d bytes, want 100", n)
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Fatalf("write finished in %v, too fast for the configured rate", elapsed)
+	}
+}
+
+func TestWriterChunksLargeWrites(t *testing.T) {
+	tb := NewBucket(time.Second, 100, 100, 100)
+	var buf bytes.Buffer
+	w := NewWriter(&buf, tb)
+
+	n, err := w.Write(make([]byte, 1000))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 1000 {
+		t.Fatalf("wrote %d bytes, want 1000 (split across several chunks)", n)
+	}
+	if buf.Len() != 1000 {
+		t.Fatalf("underlying writer received %d bytes, want 1000", buf.Len())
+	}
+}
+
+func TestReaderCtxCancellation(t *testing.T) {
+	tb := NewBucket(time.Second, 1, 1, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	r := NewReaderCtx(ctx, bytes.NewReader([]byte("x")), tb)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		done <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("err = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read did not return after cancellation")
+	}
+}
@@ -0,0 +1,128 @@
+package ratelimit
+
+import "time"
+
+// Reservation holds information about tokens reserved from a Bucket for
+// future use. It is returned by Bucket.Reserve and Bucket.ReserveMaxWait,
+// and lets a caller that speculatively reserved capacity for work it may
+// end up not doing give the tokens back with Cancel.
+type Reservation struct {
+	ok        bool
+	bucket    *Bucket
+	tokens    int64
+	timeToAct time.Time
+}
+
+// OK reports whether the reservation was granted. A reservation made
+// with ReserveMaxWait is not granted if the wait required to obtain the
+// tokens would exceed maxWait; in that case no tokens are removed from
+// the bucket.
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Delay is shorthand for DelayFrom(time.Now()).
+func (r *Reservation) Delay() time.Duration {
+	return r.DelayFrom(time.Now())
+}
+
+// DelayFrom returns the duration that the caller should wait from now
+// until the reserved tokens are available for use. It returns zero if
+// they are already available, and infinityDuration if the reservation
+// was not OK.
+func (r *Reservation) DelayFrom(now time.Time) time.Duration {
+	if !r.ok {
+		return infinityDuration
+	}
+	if d := r.timeToAct.Sub(now); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// Cancel is shorthand for CancelAt(time.Now()).
+func (r *Reservation) Cancel() {
+	r.CancelAt(time.Now())
+}
+
+// CancelAt indicates that the reservation holder will not perform the
+// reserved action and so the tokens should be returned to the bucket, if
+// possible. Tokens are given back only if this is still the most recent
+// reservation made on the bucket and it has not yet come due; if a later
+// Take, Wait or Reserve call has already relied on this reservation's
+// tokens being spent, cancelling has no effect.
+func (r *Reservation) CancelAt(now time.Time) {
+	if !r.ok || r.tokens == 0 {
+		return
+	}
+	tb := r.bucket
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	if tb.lastReservationTime != r.timeToAct {
+		// A later reservation has already been made; our tokens may
+		// have been relied upon, so leave the bucket alone.
+		return
+	}
+	if r.timeToAct.Before(now) {
+		// The reservation has already come due.
+		return
+	}
+
+	tb.adjustavailableTokens(tb.currentTick(now))
+	tb.availableTokens += tb.lastReservationTokens
+	if tb.availableTokens > tb.capacity {
+		tb.availableTokens = tb.capacity
+	}
+	tb.lastReservationTokens = 0
+	tb.lastReservationTime = time.Time{}
+}
+
+// Reserve reserves count tokens from the bucket for future use, without
+// any limit on how long the caller might have to wait for them. It is
+// the building block that Take and Wait are implemented in terms of.
+func (tb *Bucket) Reserve(count int64) *Reservation {
+	return tb.reserve(time.Now(), count, infinityDuration)
+}
+
+// ReserveMaxWait is like Reserve except that if the wait needed to
+// obtain count tokens would exceed maxWait, no tokens are reserved and
+// the returned Reservation's OK method reports false.
+func (tb *Bucket) ReserveMaxWait(count int64, maxWait time.Duration) *Reservation {
+	return tb.reserve(time.Now(), count, maxWait)
+}
+
+// reserve is the internal version of Reserve - it takes the current time
+// as an argument to enable easy testing.
+func (tb *Bucket) reserve(now time.Time, count int64, maxWait time.Duration) *Reservation {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tick := tb.currentTick(now)
+	tb.adjustavailableTokens(tick)
+	avail := tb.availableTokens - count
+
+	var waitTime time.Duration
+	if avail < 0 {
+		// Round up the missing tokens to the nearest multiple of
+		// quantum - the tokens won't be available until that tick.
+		endTick := tick + (-avail+tb.quantum-1)/tb.quantum
+		endTime := tb.startTime.Add(time.Duration(endTick) * tb.fillInterval)
+		waitTime = endTime.Sub(now)
+	}
+	if waitTime > maxWait {
+		return &Reservation{ok: false}
+	}
+
+	tb.availableTokens = avail
+	timeToAct := now.Add(waitTime)
+	tb.lastReservationTokens = count
+	tb.lastReservationTime = timeToAct
+
+	return &Reservation{
+		ok:        true,
+		bucket:    tb,
+		tokens:    count,
+		timeToAct: timeToAct,
+	}
+}
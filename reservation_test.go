@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReserveImmediate(t *testing.T) {
+	tb := NewBucket(time.Second, 10, 10, 10)
+	r := tb.Reserve(5)
+	if !r.OK() {
+		t.Fatal("reservation should have been granted")
+	}
+	if d := r.Delay(); d != 0 {
+		t.Fatalf("delay = %v, want 0", d)
+	}
+	if avail := tb.Available(); avail != 5 {
+		t.Fatalf("available = %d, want 5", avail)
+	}
+}
+
+func TestReserveMaxWaitTooLong(t *testing.T) {
+	tb := NewBucket(time.Second, 10, 10, 0)
+	r := tb.ReserveMaxWait(10, 10*time.Millisecond)
+	if r.OK() {
+		t.Fatal("reservation should not have been granted")
+	}
+	if avail := tb.Available(); avail != 0 {
+		t.Fatalf("available = %d, want 0 (no tokens taken for a failed reservation)", avail)
+	}
+}
+
+func TestReservationCancelRestoresTokens(t *testing.T) {
+	// Reserve more than is currently available, so the reservation has
+	// to wait and hence hasn't come due yet by the time we cancel it.
+	tb := NewBucket(time.Second, 10, 10, 0)
+	r := tb.Reserve(4)
+	if !r.OK() {
+		t.Fatal("reservation should have been granted")
+	}
+	if d := r.Delay(); d <= 0 {
+		t.Fatalf("delay = %v, want > 0 so cancelling has something to undo", d)
+	}
+	r.Cancel()
+	if avail := tb.Available(); avail != 0 {
+		t.Fatalf("available = %d, want 0 (back to the pre-reservation state)", avail)
+	}
+}
+
+func TestReservationCancelAfterLaterReservationIsNoop(t *testing.T) {
+	tb := NewBucket(100*time.Millisecond, 10, 1, 0)
+	r1 := tb.Reserve(5)
+	r2 := tb.Reserve(1)
+	if !r1.OK() || !r2.OK() {
+		t.Fatal("both reservations should have been granted")
+	}
+	r1.Cancel()
+	if avail := tb.Available(); avail != -6 {
+		t.Fatalf("available = %d, want -6 (cancelling a superseded reservation should be a no-op)", avail)
+	}
+}
+
+func TestReservationCancelAfterItCameDueIsNoop(t *testing.T) {
+	tb := NewBucket(10*time.Millisecond, 1, 1, 0)
+	r := tb.Reserve(1)
+	time.Sleep(20 * time.Millisecond)
+	r.Cancel()
+	if avail := tb.Available(); avail != 1 {
+		t.Fatalf("available = %d, want 1 (the fill refilled it, Cancel shouldn't double-count)", avail)
+	}
+}
+
+func TestTakeCtxCancelDoesNotOverCreditWhenSuperseded(t *testing.T) {
+	tb := NewBucket(time.Second, 100, 100, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Reserve 50 tokens via TakeCtx; it has to wait, so it hasn't come
+	// due by the time we cancel it below.
+	errc := make(chan error, 1)
+	go func() {
+		_, err := tb.TakeCtx(ctx, 50)
+		errc <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// A later Take goes further into debt, relying on the first
+	// reservation's tokens being spent.
+	tb.Take(50)
+	if avail := tb.Available(); avail != -100 {
+		t.Fatalf("available = %d, want -100 after both reservations", avail)
+	}
+
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+
+	if avail := tb.Available(); avail != -100 {
+		t.Fatalf("available = %d, want -100 (cancelling a superseded TakeCtx must not hand back tokens)", avail)
+	}
+}
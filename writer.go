@@ -0,0 +1,54 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+)
+
+// Writer wraps an io.Writer so that each byte written to it also
+// consumes one token from a Bucket, blocking via Bucket.Wait so that the
+// rate of bytes written never exceeds the bucket's configured rate.
+type Writer struct {
+	w      io.Writer
+	bucket *Bucket
+	ctx    context.Context
+}
+
+// NewWriter returns an io.Writer that writes to w but blocks so that the
+// rate of bytes written never exceeds the rate of b.
+func NewWriter(w io.Writer, b *Bucket) io.Writer {
+	return &Writer{w: w, bucket: b, ctx: context.Background()}
+}
+
+// NewWriterCtx is like NewWriter except that a Write which would
+// otherwise block returns ctx.Err() promptly if ctx is cancelled or its
+// deadline passes.
+func NewWriterCtx(ctx context.Context, w io.Writer, b *Bucket) io.Writer {
+	return &Writer{w: w, bucket: b, ctx: ctx}
+}
+
+// Write implements io.Writer. A large write is split into chunks no
+// bigger than the bucket's capacity, so that a single call doesn't
+// monopolize the bucket's tokens for the whole transfer.
+func (w *Writer) Write(buf []byte) (int, error) {
+	max := w.bucket.Capacity()
+	written := 0
+	for len(buf) > 0 {
+		chunk := buf
+		if int64(len(chunk)) > max {
+			chunk = chunk[:max]
+		}
+		n, err := w.w.Write(chunk)
+		written += n
+		if n > 0 {
+			if werr := w.bucket.WaitCtx(w.ctx, int64(n)); werr != nil {
+				return written, werr
+			}
+		}
+		if err != nil {
+			return written, err
+		}
+		buf = buf[n:]
+	}
+	return written, nil
+}